@@ -85,12 +85,45 @@ Add secured registries
         "url":{{ .url | quote }},
         "user":{{ .user | quote }},
         "password":{{ .password | quote }},
-        "token":{{ .token | quote }}
+        "token":{{ .token | quote }},
+        "insecure":{{ .insecure | default false }},
+        "caFile":{{ .caFile | default "" | quote }},
+        "clientCertFile":{{ .clientCertFile | default "" | quote }},
+        "clientKeyFile":{{ .clientKeyFile | default "" | quote }}
     }
     {{- end }}
 }
 {{- end -}}
 
+{{/*
+Mount the CA bundles and client certificates of the secured registries
+*/}}
+{{- define "ops.registryTLSVolumeMounts" -}}
+{{- if .Values.registryTLS.caConfigMap }}
+- mountPath: /etc/registry-tls/ca
+  name: registry-tls-ca
+  readOnly: true
+{{- end }}
+{{- if .Values.registryTLS.clientCertSecret }}
+- mountPath: /etc/registry-tls/client
+  name: registry-tls-client
+  readOnly: true
+{{- end }}
+{{- end -}}
+
+{{- define "ops.registryTLSVolumes" -}}
+{{- if .Values.registryTLS.caConfigMap }}
+- name: registry-tls-ca
+  configMap:
+    name: {{ .Values.registryTLS.caConfigMap }}
+{{- end }}
+{{- if .Values.registryTLS.clientCertSecret }}
+- name: registry-tls-client
+  secret:
+    secretName: {{ .Values.registryTLS.clientCertSecret }}
+{{- end }}
+{{- end -}}
+
 {{/*
 Add external Black Duck
 */}}
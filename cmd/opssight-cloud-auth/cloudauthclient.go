@@ -22,6 +22,17 @@ under the License.
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -33,14 +44,28 @@ import (
 
 const (
 	refreshInMinutes = "REFRESH_IN_MINUTES"
+	refreshFraction  = "REFRESH_FRACTION"
+	statusPort       = "STATUS_PORT"
 	awsAssumeRole    = "AWS_ASSUME_ROLE"
 	awsRegion        = "AWS_REGION"
 	awsAccountIds    = "AWS_ACCOUNT_IDS"
+	ecrRegistries    = "ECR_REGISTRIES"
 	gcrURL           = "GCR_URL"
+	azureTenantID    = "AZURE_TENANT_ID"
+	azureClientID    = "AZURE_CLIENT_ID"
+	// only read from the environment
+	azureClientSecret = "AZURE_CLIENT_SECRET"
 )
 
+// acrUser is the user name that ACR refresh tokens are used with
+const acrUser = "00000000-0000-0000-0000-000000000000"
+
 var (
 	awsAccountIDs []string
+	// ecrRegistryConfigs are the configured ECR registries
+	ecrRegistryConfigs []ecrRegistry
+	// refreshFractions overrides the refresh fraction of providers
+	refreshFractions map[string]float64
 )
 
 // GCR interface
@@ -63,14 +88,128 @@ type ecrInterface interface {
 	GetAuthorizationToken(input *ecr.GetAuthorizationTokenInput) (*ecr.GetAuthorizationTokenOutput, error)
 }
 
-func newEcrClient() ecrInterface {
-	sess := session.Must(session.NewSession())
-	awsConfig := aws.NewConfig().WithRegion(*argAWSRegion)
+// ecrClientsInterface returns the ECR client for the region and credentials of a registry
+type ecrClientsInterface interface {
+	Client(registry ecrRegistry) ecrInterface
+}
+
+// ecrClients caches a client per region and role.  The default credentials chain of the
+// session includes the IRSA web identity of the pod
+type ecrClients struct {
+	sess    *session.Session
+	mutex   sync.Mutex
+	clients map[string]ecrInterface
+}
+
+func (e *ecrClients) Client(registry ecrRegistry) ecrInterface {
+	key := strings.Join([]string{registry.Region, registry.RoleARN, registry.WebIdentityTokenFile}, "|")
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if client, ok := e.clients[key]; ok {
+		return client
+	}
+
+	awsConfig := aws.NewConfig().WithRegion(registry.Region).WithCredentialsChainVerboseErrors(true)
+	if len(registry.RoleARN) > 0 && len(registry.WebIdentityTokenFile) > 0 {
+		awsConfig.Credentials = stscreds.NewWebIdentityCredentials(e.sess, registry.RoleARN, "opssight-cloud-auth", registry.WebIdentityTokenFile)
+	} else if len(registry.RoleARN) > 0 {
+		awsConfig.Credentials = stscreds.NewCredentials(e.sess, registry.RoleARN)
+	}
+	client := ecr.New(e.sess, awsConfig)
+	e.clients[key] = client
+	return client
+}
+
+func newEcrClients() ecrClientsInterface {
+	return &ecrClients{sess: session.Must(session.NewSession()), clients: map[string]ecrInterface{}}
+}
+
+// AAD interface
+type aadInterface interface {
+	// AccessToken returns an Azure Active Directory access token for the Azure Resource Manager
+	AccessToken() (string, error)
+}
 
-	if *argAWSAssumeRole != "" {
-		creds := stscreds.NewCredentials(sess, *argAWSAssumeRole)
-		awsConfig.Credentials = creds
+type aadClient struct {
+	token *adal.ServicePrincipalToken
+}
+
+func (aad aadClient) AccessToken() (string, error) {
+	if err := aad.token.EnsureFresh(); err != nil {
+		return "", err
 	}
+	return aad.token.OAuthToken(), nil
+}
+
+// newAadClient authenticates as the service principal if a client secret is given,
+// or else as the managed identity of the node, optionally the user assigned one with
+// the client id
+func newAadClient() (aadInterface, error) {
+	resource := azure.PublicCloud.ResourceManagerEndpoint
+	secret := os.Getenv(azureClientSecret)
+	if len(secret) > 0 {
+		oauthConfig, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, *argAzureTenantID)
+		if err != nil {
+			return nil, err
+		}
+		token, err := adal.NewServicePrincipalToken(*oauthConfig, *argAzureClientID, secret, resource)
+		if err != nil {
+			return nil, err
+		}
+		return aadClient{token: token}, nil
+	}
+
+	msiEndpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	var token *adal.ServicePrincipalToken
+	if len(*argAzureClientID) > 0 {
+		token, err = adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(msiEndpoint, resource, *argAzureClientID)
+	} else {
+		token, err = adal.NewServicePrincipalTokenFromMSI(msiEndpoint, resource)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return aadClient{token: token}, nil
+}
+
+// ACR interface
+type acrInterface interface {
+	// ExchangeAADToken exchanges an Azure Active Directory access token for a refresh token of the registry
+	ExchangeAADToken(registry string, tenant string, aadAccessToken string) (string, error)
+}
+
+type acrClient struct {
+	client *http.Client
+}
+
+func (acr acrClient) ExchangeAADToken(registry string, tenant string, aadAccessToken string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "access_token")
+	form.Set("service", registry)
+	form.Set("access_token", aadAccessToken)
+	if len(tenant) > 0 {
+		form.Set("tenant", tenant)
+	}
+	resp, err := acr.client.PostForm(fmt.Sprintf("https://%s/oauth2/exchange", registry), form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("exchange of the AAD token for %s returned status code %d", registry, resp.StatusCode)
+	}
+	var exchange struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&exchange); err != nil {
+		return "", fmt.Errorf("unable to decode the refresh token of %s: %v", registry, err)
+	}
+	return exchange.RefreshToken, nil
+}
 
-	return ecr.New(sess, awsConfig)
+func newAcrClient() acrInterface {
+	return acrClient{client: &http.Client{Timeout: 30 * time.Second}}
 }
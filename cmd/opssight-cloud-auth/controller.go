@@ -23,27 +23,33 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	opssightapi "github.com/blackducksoftware/synopsys-operator/pkg/api/opssight/v1"
 	opssightclientset "github.com/blackducksoftware/synopsys-operator/pkg/opssight/client/clientset/versioned"
 	"github.com/blackducksoftware/synopsys-operator/pkg/util"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
-// AuthToken will store the providers auth token
+// AuthToken will store the providers auth token.  If a user is given,
+// the token must be used with it.  ExpiresAt is zero if the expiry is unknown
 type AuthToken struct {
 	AccessToken string
 	Endpoint    string
+	User        string
+	ExpiresAt   time.Time
 }
 
 // TokenGenerator will store the token provider function
@@ -55,11 +61,20 @@ type TokenGenerator struct {
 type controller struct {
 	kubeConfig *rest.Config
 	kubeClient *kubernetes.Clientset
-	ecrClient  ecrInterface
+	ecrClients ecrClientsInterface
 	gcrClient  gcrInterface
+	aadClient  aadInterface
+	acrClient  acrInterface
+
+	refreshInterval        time.Duration
+	defaultRefreshFraction float64
+	refreshFractions       map[string]float64
+	listFailures           int
+	statusMutex            sync.Mutex
+	providerStatuses       map[string]*providerStatus
 }
 
-// RegistryAuth ...
+// RegistryAuth is the credential of a secured registry, as read by the image getter
 type RegistryAuth struct {
 	URL      string
 	User     string
@@ -84,65 +99,224 @@ func (c *controller) getGCRAuthorizationKey() ([]AuthToken, error) {
 	}
 
 	if token.Type() != "Bearer" {
-		return []AuthToken{}, fmt.Errorf(fmt.Sprintf("expected token type \"Bearer\" but got \"%s\"", token.Type()))
+		return []AuthToken{}, fmt.Errorf("expected token type \"Bearer\" but got \"%s\"", token.Type())
 	}
 
 	return []AuthToken{
 		AuthToken{
 			AccessToken: token.AccessToken,
-			Endpoint:    *argGCRURL},
+			Endpoint:    *argGCRURL,
+			ExpiresAt:   token.Expiry},
 	}, nil
 }
 
-// getECRAuthorizationKey will get the authorization key from Elastic Container Registry (ECR)
-func (c *controller) getECRAuthorizationKey() ([]AuthToken, error) {
+// ecrRegistry is the account, region and credentials used to get the token of an
+// Elastic Container Registry (ECR).  If a role is given, it is assumed with the default
+// credentials, or with the web identity token in the file if one is given
+type ecrRegistry struct {
+	URL                  string `json:"url"`
+	AccountID            string `json:"accountId"`
+	Region               string `json:"region"`
+	RoleARN              string `json:"roleArn"`
+	WebIdentityTokenFile string `json:"webIdentityTokenFile"`
+}
 
-	if len(awsAccountIDs) == 0 {
-		return []AuthToken{}, fmt.Errorf("unable to get auth token for ECR account due to missing account id's")
-	}
+// ecrURLRegexp matches ECR registry hosts such as 123456789012.dkr.ecr.us-east-1.amazonaws.com
+var ecrURLRegexp = regexp.MustCompile(`^([0-9]{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
 
-	var tokens []AuthToken
-	var regIds []*string
-	regIds = make([]*string, len(awsAccountIDs))
+// parseECRURL returns the account and region of an ECR registry url
+func parseECRURL(url string) (string, string, bool) {
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(url), "https://"), "http://")
+	match := ecrURLRegexp.FindStringSubmatch(strings.Split(host, "/")[0])
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
 
-	for i, awsAccountID := range awsAccountIDs {
-		regIds[i] = aws.String(awsAccountID)
+// parseECRRegistries parses the configured ECR registries, filling in the account and
+// region of the registry url if they are missing
+func parseECRRegistries(config string) ([]ecrRegistry, error) {
+	registries := []ecrRegistry{}
+	if err := json.Unmarshal([]byte(config), &registries); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal the ECR registries due to %+v", err)
 	}
+	for i, registry := range registries {
+		registry.URL = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(registry.URL), "https://"), "http://")
+		accountID, region, ok := parseECRURL(registry.URL)
+		if len(registry.AccountID) == 0 {
+			registry.AccountID = accountID
+		}
+		if len(registry.Region) == 0 {
+			registry.Region = region
+		}
+		if !ok && (len(registry.AccountID) == 0 || len(registry.Region) == 0) {
+			return nil, fmt.Errorf("the account and region of ECR registry %s must be given", registry.URL)
+		}
+		registries[i] = registry
+	}
+	return registries, nil
+}
 
-	sess := session.Must(session.NewSession())
-	awsConfig := aws.NewConfig().WithRegion(*argAWSRegion).WithCredentialsChainVerboseErrors(true)
+// getECRRegistries returns the configured ECR registries, followed by the ECR registries in
+// the internal registries of the OpsSights, which use the default region and role
+func getECRRegistries(opssights []opssightapi.OpsSight) []ecrRegistry {
+	registries := []ecrRegistry{}
+	found := map[string]bool{}
+	for _, registry := range ecrRegistryConfigs {
+		if !found[registry.URL] {
+			found[registry.URL] = true
+			registries = append(registries, registry)
+		}
+	}
+	for _, opssight := range opssights {
+		for _, internalRegistry := range opssight.Spec.ScannerPod.ImageFacade.InternalRegistries {
+			url := strings.ToLower(internalRegistry.URL)
+			accountID, region, ok := parseECRURL(url)
+			if !ok || found[url] {
+				continue
+			}
+			found[url] = true
+			registries = append(registries, ecrRegistry{URL: url, AccountID: accountID, Region: region, RoleARN: *argAWSAssumeRole})
+		}
+	}
+	return registries
+}
 
-	if *argAWSAssumeRole != "" {
-		creds := stscreds.NewCredentials(sess, *argAWSAssumeRole)
-		awsConfig.Credentials = creds
+// getECRAuthorizationKey will get an authorization key for each Elastic Container Registry (ECR),
+// with one request per region and role
+func (c *controller) getECRAuthorizationKey(registries []ecrRegistry) ([]AuthToken, error) {
+	groups := map[string][]ecrRegistry{}
+	keys := []string{}
+	for _, registry := range registries {
+		key := strings.Join([]string{registry.Region, registry.RoleARN, registry.WebIdentityTokenFile}, "|")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], registry)
 	}
 
-	svc := ecr.New(sess, awsConfig)
+	tokens := []AuthToken{}
+	errors := []string{}
+	for _, key := range keys {
+		group := groups[key]
+		registryIDs := []*string{}
+		for _, registry := range group {
+			registryIDs = append(registryIDs, aws.String(registry.AccountID))
+		}
+		resp, err := c.ecrClients.Client(group[0]).GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{RegistryIds: registryIDs})
+		if err != nil {
+			log.Errorf("unable to get ECR auth token for region %s because %+v", group[0].Region, err)
+			errors = append(errors, err.Error())
+			continue
+		}
 
-	params := &ecr.GetAuthorizationTokenInput{
-		RegistryIds: regIds,
+		for _, registry := range group {
+			token, ok := ecrAuthorizationData(resp, registry)
+			if !ok {
+				log.Errorf("no ECR auth token was returned for %s", registry.URL)
+				errors = append(errors, fmt.Sprintf("no token for %s", registry.URL))
+				continue
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	if len(errors) > 0 {
+		// Return the tokens that were retrieved, they are applied even though the refresh is retried
+		return tokens, fmt.Errorf("unable to get ECR auth tokens: %s", strings.Join(errors, ", "))
 	}
+	return tokens, nil
+}
 
-	resp, err := svc.GetAuthorizationToken(params)
+// ecrAuthorizationData returns the token for the account of the registry
+func ecrAuthorizationData(resp *ecr.GetAuthorizationTokenOutput, registry ecrRegistry) (AuthToken, bool) {
+	for _, auth := range resp.AuthorizationData {
+		accountID, _, ok := parseECRURL(aws.StringValue(auth.ProxyEndpoint))
+		if !ok || accountID != registry.AccountID {
+			continue
+		}
+		return AuthToken{
+			AccessToken: aws.StringValue(auth.AuthorizationToken),
+			Endpoint:    registry.URL,
+			User:        "AWS",
+			ExpiresAt:   aws.TimeValue(auth.ExpiresAt),
+		}, true
+	}
+	return AuthToken{}, false
+}
 
+// getACRAuthorizationKey will exchange an Azure Active Directory token for a refresh token
+// of each of the Azure Container Registries (ACR)
+func (c *controller) getACRAuthorizationKey(registries []string) ([]AuthToken, error) {
+	aadToken, err := c.aadClient.AccessToken()
 	if err != nil {
-		// Print the error, cast err to awserr.Error to get the Code and
-		// Message from an error.
-		log.Errorf("unable to get ECR auth token because %+v", err)
-		return []AuthToken{}, err
+		return []AuthToken{}, fmt.Errorf("unable to get azure active directory token because %+v", err)
 	}
 
-	for _, auth := range resp.AuthorizationData {
+	tokens := []AuthToken{}
+	for _, registry := range registries {
+		refreshToken, err := c.acrClient.ExchangeAADToken(registry, *argAzureTenantID, aadToken)
+		if err != nil {
+			log.Errorf("unable to get ACR auth token for %s because %+v", registry, err)
+			continue
+		}
 		tokens = append(tokens, AuthToken{
-			AccessToken: *auth.AuthorizationToken,
-			Endpoint:    *auth.ProxyEndpoint,
+			AccessToken: refreshToken,
+			Endpoint:    registry,
+			User:        acrUser,
+			ExpiresAt:   jwtExpiry(refreshToken),
 		})
-
+	}
+	if len(tokens) == 0 {
+		return tokens, fmt.Errorf("unable to get ACR auth token for any of %s", strings.Join(registries, ","))
 	}
 	return tokens, nil
 }
 
-func (c *controller) getTokenGenerators() []TokenGenerator {
+// jwtExpiry returns the expiry claim of a JSON web token without verifying it,
+// or zero if it has none
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// getACRRegistries returns the Azure Container Registries in the internal registries of the OpsSights
+func getACRRegistries(opssights []opssightapi.OpsSight) []string {
+	registries := []string{}
+	found := map[string]bool{}
+	for _, opssight := range opssights {
+		for _, registry := range opssight.Spec.ScannerPod.ImageFacade.InternalRegistries {
+			url := strings.ToLower(registry.URL)
+			if isACRRegistry(url) && !found[url] {
+				found[url] = true
+				registries = append(registries, url)
+			}
+		}
+	}
+	return registries
+}
+
+// isACRRegistry returns whether the registry url is an Azure Container Registry login server,
+// such as myregistry.azurecr.io
+func isACRRegistry(url string) bool {
+	host := strings.Split(url, "/")[0]
+	return strings.Contains(host, ".azurecr.")
+}
+
+func (c *controller) getTokenGenerators(opssights []opssightapi.OpsSight) []TokenGenerator {
 	tokenGenerators := []TokenGenerator{}
 
 	tokenGenerators = append(tokenGenerators, TokenGenerator{
@@ -150,10 +324,26 @@ func (c *controller) getTokenGenerators() []TokenGenerator {
 		Name:        "GCR",
 	})
 
-	tokenGenerators = append(tokenGenerators, TokenGenerator{
-		TokenGenFxn: c.getECRAuthorizationKey,
-		Name:        "ECR",
-	})
+	ecrRegistries := getECRRegistries(opssights)
+	if len(ecrRegistries) > 0 {
+		tokenGenerators = append(tokenGenerators, TokenGenerator{
+			TokenGenFxn: func() ([]AuthToken, error) {
+				return c.getECRAuthorizationKey(ecrRegistries)
+			},
+			Name: "ECR",
+		})
+	}
+
+	// Only authenticate to Azure if an OpsSight uses ACR
+	acrRegistries := getACRRegistries(opssights)
+	if len(acrRegistries) > 0 {
+		tokenGenerators = append(tokenGenerators, TokenGenerator{
+			TokenGenFxn: func() ([]AuthToken, error) {
+				return c.getACRAuthorizationKey(acrRegistries)
+			},
+			Name: "ACR",
+		})
+	}
 
 	return tokenGenerators
 }
@@ -170,6 +360,11 @@ func (c *controller) getTokenEndpoint(tokenEndPoint string) string {
 }
 
 func (c *controller) getTokenPassword(tokenEndPoint string, tokenAccessToken string, tokenType string) (string, error) {
+	// ACR refresh tokens are not encoded
+	if strings.EqualFold(tokenType, "ACR") {
+		return tokenAccessToken, nil
+	}
+
 	data, err := base64.StdEncoding.DecodeString(tokenAccessToken)
 	if err != nil {
 		return "", fmt.Errorf("unable to decode the password for token endpoint: %s", tokenEndPoint)
@@ -181,78 +376,208 @@ func (c *controller) getTokenPassword(tokenEndPoint string, tokenAccessToken str
 	return strdata, nil
 }
 
+// credentialsSecretKey is the key of the registry credentials in the credentials Secret, which are
+// in the format of the secured registries read by the image getter
+const credentialsSecretKey = "securedRegistries.json"
+
+// credentialsSecretName returns the name of the Secret holding the registry credentials of the OpsSight
+func credentialsSecretName(opssight *opssightapi.OpsSight) string {
+	if len(opssight.Spec.ScannerPod.ImageFacade.CredentialsSecret) > 0 {
+		return opssight.Spec.ScannerPod.ImageFacade.CredentialsSecret
+	}
+	return fmt.Sprintf("%s-opssight-registry-credentials", opssight.Name)
+}
+
+// opssightNamespace returns the namespace that the OpsSight is deployed in
+func opssightNamespace(opssight *opssightapi.OpsSight) string {
+	if len(opssight.Spec.Namespace) > 0 {
+		return opssight.Spec.Namespace
+	}
+	return opssight.Namespace
+}
+
+// updateOpsSightWithAuthToken writes the tokens of the OpsSight internal registries to its credentials Secret,
+// and makes sure the OpsSight only references the Secret instead of holding the passwords
 func (c *controller) updateOpsSightWithAuthToken(opssightClient *opssightclientset.Clientset, opssight *opssightapi.OpsSight, tokens []AuthToken, tokenType string) error {
+	credentials := c.registryCredentials(opssight, tokens, tokenType)
+	if len(credentials) == 0 {
+		return nil
+	}
+	if err := c.updateCredentialsSecret(opssight, credentials); err != nil {
+		return err
+	}
+	if !referenceCredentialsSecret(opssight, credentials) {
+		return nil
+	}
+	updated, err := util.UpdateOpsSight(opssightClient, "", opssight)
+	if err != nil {
+		return err
+	}
+	// Keep the new resource version for the updates of the other providers
+	*opssight = *updated
+	return nil
+}
+
+// registryCredentials returns the credentials of the OpsSight internal registries that match the tokens, by registry url
+func (c *controller) registryCredentials(opssight *opssightapi.OpsSight, tokens []AuthToken, tokenType string) map[string]*RegistryAuth {
+	credentials := map[string]*RegistryAuth{}
 	for _, token := range tokens {
 		endPoint := c.getTokenEndpoint(token.Endpoint)
-		for i := range opssight.Spec.ScannerPod.ImageFacade.InternalRegistries {
-			if strings.EqualFold(endPoint, opssight.Spec.ScannerPod.ImageFacade.InternalRegistries[i].URL) {
+		for _, registry := range opssight.Spec.ScannerPod.ImageFacade.InternalRegistries {
+			if strings.EqualFold(endPoint, registry.URL) {
 				log.Infof("found %s url in opssight private registries", endPoint)
 				strdata, err := c.getTokenPassword(endPoint, token.AccessToken, tokenType)
 				if err != nil {
 					log.Errorf("unable to get the token password for %s because %+v", endPoint, err)
 					continue
 				}
-				opssight.Spec.ScannerPod.ImageFacade.InternalRegistries[i].Password = strdata
+				user := registry.User
+				if len(token.User) > 0 {
+					user = token.User
+				}
+				credentials[registry.URL] = &RegistryAuth{URL: registry.URL, User: user, Password: strdata}
 			}
 		}
 	}
+	return credentials
+}
 
-	// AWS EKS specific - if the registry url that is present in OpsSight Configmap but not in AWS token list,
-	// then update the token with the 1st endpoint's password. This is because the EKS kube pods are from
-	// different account id than the used one
+// updateCredentialsSecret merges the credentials into the credentials Secret of the OpsSight,
+// creating the Secret if it doesn't exist.  The Secret is only updated if a credential changed
+func (c *controller) updateCredentialsSecret(opssight *opssightapi.OpsSight, credentials map[string]*RegistryAuth) error {
+	namespace, name := opssightNamespace(opssight), credentialsSecretName(opssight)
+	secret, err := util.GetSecret(c.kubeClient, namespace, name)
+	if k8serrors.IsNotFound(err) {
+		data, _, err := mergeRegistryCredentials(nil, credentials)
+		if err != nil {
+			return err
+		}
+		log.Infof("creating the registry credentials secret %s in %s", name, namespace)
+		if _, err = util.CreateSecret(c.kubeClient, namespace, name, map[string]string{credentialsSecretKey: string(data)}); err != nil {
+			return fmt.Errorf("unable to create secret %s in %s due to %+v", name, namespace, err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to get secret %s in %s due to %+v", name, namespace, err)
+	}
 
-	if strings.EqualFold(tokenType, "ECR") {
-		for i := range opssight.Spec.ScannerPod.ImageFacade.InternalRegistries {
-			isRegistryExists := false
-			for _, token := range tokens {
-				endPoint := c.getTokenEndpoint(token.Endpoint)
-
-				if strings.EqualFold(endPoint, opssight.Spec.ScannerPod.ImageFacade.InternalRegistries[i].URL) {
-					isRegistryExists = true
-					break
-				}
-			}
+	data, changed, err := mergeRegistryCredentials(secret.Data[credentialsSecretKey], credentials)
+	if err != nil {
+		return fmt.Errorf("unable to update secret %s in %s due to %+v", name, namespace, err)
+	}
+	if !changed {
+		log.Debugf("the registry credentials in secret %s in %s are up to date", name, namespace)
+		return nil
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[credentialsSecretKey] = data
+	if _, err = util.UpdateSecret(c.kubeClient, namespace, secret); err != nil {
+		return fmt.Errorf("unable to update secret %s in %s due to %+v", name, namespace, err)
+	}
+	return nil
+}
 
-			if !isRegistryExists && len(tokens) > 0 && strings.HasSuffix(opssight.Spec.ScannerPod.ImageFacade.InternalRegistries[i].URL, "amazonaws.com") {
-				strdata, err := c.getTokenPassword(tokens[0].Endpoint, tokens[0].AccessToken, tokenType)
-				if err != nil {
-					log.Errorf("unable to get the token password for %s because %+v", tokens[0].Endpoint, err)
-					continue
-				}
-				opssight.Spec.ScannerPod.ImageFacade.InternalRegistries[i].Password = strdata
-			}
+// mergeRegistryCredentials merges the credentials into the secured registries, and returns whether any changed
+func mergeRegistryCredentials(data []byte, credentials map[string]*RegistryAuth) ([]byte, bool, error) {
+	registries := map[string]*RegistryAuth{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &registries); err != nil {
+			return nil, false, fmt.Errorf("unable to unmarshal the secured registries due to %+v", err)
 		}
 	}
+	changed := false
+	for url, credential := range credentials {
+		if registry, ok := registries[url]; ok && *registry == *credential {
+			continue
+		}
+		registries[url] = credential
+		changed = true
+	}
+	merged, err := json.Marshal(registries)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to marshal the secured registries due to %+v", err)
+	}
+	return merged, changed, nil
+}
 
-	_, err := util.UpdateOpsSight(opssightClient, "", opssight)
-	return err
+// referenceCredentialsSecret makes the OpsSight reference its credentials Secret and removes the passwords
+// of the registries whose credentials are in the Secret.  It returns whether the OpsSight changed
+func referenceCredentialsSecret(opssight *opssightapi.OpsSight, credentials map[string]*RegistryAuth) bool {
+	imageFacade := opssight.Spec.ScannerPod.ImageFacade
+	changed := false
+	if len(imageFacade.CredentialsSecret) == 0 {
+		imageFacade.CredentialsSecret = credentialsSecretName(opssight)
+		changed = true
+	}
+	for _, registry := range imageFacade.InternalRegistries {
+		if _, ok := credentials[registry.URL]; ok && len(registry.Password) > 0 {
+			registry.Password = ""
+			changed = true
+		}
+	}
+	return changed
 }
 
-func (c *controller) updateAuthTokens() error {
-	log.Print("Refreshing credentials...")
-	tokenGenerators := c.getTokenGenerators()
-	opsSightClient, err := opssightclientset.NewForConfig(c.kubeConfig)
-	if err != nil {
-		return fmt.Errorf("error in creating the opssight client due to %+v", err)
+// run refreshes the tokens of each provider when they are due until stopped
+func (c *controller) run(stopCh <-chan struct{}) {
+	for {
+		delay := c.updateAuthTokens(time.Now())
+		log.Debugf("next credentials refresh in %s", delay)
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(delay):
+		}
 	}
-	opssights, err := util.ListOpsSights(opsSightClient, "", metav1.ListOptions{})
+}
+
+// updateAuthTokens refreshes the tokens of the providers that are due, and returns how
+// long to wait before the next provider is due.  New OpsSights and registries are picked
+// up at least every refresh interval
+func (c *controller) updateAuthTokens(now time.Time) time.Duration {
+	log.Print("Refreshing credentials...")
+	opssights, opsSightClient, err := c.listOpsSights()
 	if err != nil {
-		return fmt.Errorf("error in getting the list of opssight due to %+v", err)
+		c.listFailures++
+		delay := c.retryDelay(c.listFailures)
+		log.Errorf("%+v, retrying in %s", err, delay)
+		return delay
 	}
+	c.listFailures = 0
+
+	next := now.Add(c.refreshInterval)
+	tokenGenerators := c.getTokenGenerators(opssights)
 	for _, tokenGenerator := range tokenGenerators {
-		newTokens, err := tokenGenerator.TokenGenFxn()
-		if err != nil {
-			log.Infof("error getting tokens for provider %s. Skipping cloud provider! [Err: %s]", tokenGenerator.Name, err)
-			continue
-		}
-		for _, opssight := range opssights.Items {
-			log.Debugf("new tokens for %s provider is %+v", tokenGenerator.Name, newTokens)
-			err = c.updateOpsSightWithAuthToken(opsSightClient, &opssight, newTokens, tokenGenerator.Name)
-			if err != nil {
-				log.Error(err)
-				continue
+		tokenType := tokenGenerator.Name
+		nextRefresh := c.refreshProvider(tokenGenerator, now, func(tokens []AuthToken) error {
+			errors := []string{}
+			for i := range opssights {
+				if err := c.updateOpsSightWithAuthToken(opsSightClient, &opssights[i], tokens, tokenType); err != nil {
+					errors = append(errors, fmt.Sprintf("%s: %+v", opssights[i].Name, err))
+				}
+			}
+			if len(errors) > 0 {
+				return fmt.Errorf("unable to update opssights %s", strings.Join(errors, ", "))
 			}
+			return nil
+		})
+		if nextRefresh.Before(next) {
+			next = nextRefresh
 		}
 	}
-	return nil
+	return next.Sub(now)
+}
+
+func (c *controller) listOpsSights() ([]opssightapi.OpsSight, *opssightclientset.Clientset, error) {
+	opsSightClient, err := opssightclientset.NewForConfig(c.kubeConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in creating the opssight client due to %+v", err)
+	}
+	opssights, err := util.ListOpsSights(opsSightClient, "", metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in getting the list of opssight due to %+v", err)
+	}
+	return opssights.Items, opsSightClient, nil
 }
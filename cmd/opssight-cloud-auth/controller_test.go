@@ -0,0 +1,377 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	opssightapi "github.com/blackducksoftware/synopsys-operator/pkg/api/opssight/v1"
+)
+
+type fakeAadClient struct {
+	token string
+	err   error
+}
+
+func (aad fakeAadClient) AccessToken() (string, error) {
+	return aad.token, aad.err
+}
+
+// fakeAcrClient exchanges the AAD token for a refresh token of the known registries
+type fakeAcrClient struct {
+	registries map[string]string
+}
+
+func (acr fakeAcrClient) ExchangeAADToken(registry string, tenant string, aadAccessToken string) (string, error) {
+	refreshToken, ok := acr.registries[registry]
+	if !ok || aadAccessToken != "aad-token" {
+		return "", fmt.Errorf("unauthorized")
+	}
+	return refreshToken, nil
+}
+
+func newTestOpsSight(urls ...string) opssightapi.OpsSight {
+	opssight := opssightapi.OpsSight{Spec: opssightapi.OpsSightSpec{ScannerPod: &opssightapi.ScannerPod{ImageFacade: &opssightapi.ImageFacade{}}}}
+	for _, url := range urls {
+		opssight.Spec.ScannerPod.ImageFacade.InternalRegistries = append(opssight.Spec.ScannerPod.ImageFacade.InternalRegistries, &opssightapi.RegistryAuth{URL: url, User: "user", Password: "password"})
+	}
+	return opssight
+}
+
+// registryUsersAndPasswords returns the users and passwords of the OpsSight internal registries,
+// taken from the credentials if they have the registry
+func registryUsersAndPasswords(opssight opssightapi.OpsSight, credentials map[string]*RegistryAuth) ([]string, []string) {
+	users, passwords := []string{}, []string{}
+	for _, registry := range opssight.Spec.ScannerPod.ImageFacade.InternalRegistries {
+		if credential, ok := credentials[registry.URL]; ok {
+			users = append(users, credential.User)
+			passwords = append(passwords, credential.Password)
+		} else {
+			users = append(users, registry.User)
+			passwords = append(passwords, registry.Password)
+		}
+	}
+	return users, passwords
+}
+
+func TestACRAuthorizationKey(t *testing.T) {
+	acr := fakeAcrClient{registries: map[string]string{"team.azurecr.io": "team-refresh", "prod.azurecr.io": "prod-refresh"}}
+	testcases := []struct {
+		description       string
+		aad               fakeAadClient
+		registries        []string
+		expectedACR       bool
+		expectedUsers     []string
+		expectedPasswords []string
+	}{
+		{
+			description:       "registries in ACR and elsewhere",
+			aad:               fakeAadClient{token: "aad-token"},
+			registries:        []string{"team.azurecr.io", "Prod.azurecr.io", "gcr.io", "docker.io"},
+			expectedACR:       true,
+			expectedUsers:     []string{acrUser, acrUser, "user", "user"},
+			expectedPasswords: []string{"team-refresh", "prod-refresh", "password", "password"},
+		},
+		{
+			description:       "unknown ACR registry",
+			aad:               fakeAadClient{token: "aad-token"},
+			registries:        []string{"team.azurecr.io", "other.azurecr.io"},
+			expectedACR:       true,
+			expectedUsers:     []string{acrUser, "user"},
+			expectedPasswords: []string{"team-refresh", "password"},
+		},
+		{
+			description:       "azure active directory error",
+			aad:               fakeAadClient{err: fmt.Errorf("no identity")},
+			registries:        []string{"team.azurecr.io"},
+			expectedACR:       true,
+			expectedUsers:     []string{"user"},
+			expectedPasswords: []string{"password"},
+		},
+		{
+			description:       "no ACR registries",
+			aad:               fakeAadClient{err: fmt.Errorf("not on azure")},
+			registries:        []string{"gcr.io", "myacr.example.com"},
+			expectedUsers:     []string{"user", "user"},
+			expectedPasswords: []string{"password", "password"},
+		},
+	}
+
+	for _, tc := range testcases {
+		c := &controller{aadClient: tc.aad, acrClient: acr}
+		opssight := newTestOpsSight(tc.registries...)
+
+		var generator *TokenGenerator
+		for _, tokenGenerator := range c.getTokenGenerators([]opssightapi.OpsSight{opssight}) {
+			if tokenGenerator.Name == "ACR" {
+				generator = &tokenGenerator
+			}
+		}
+		if (generator != nil) != tc.expectedACR {
+			t.Errorf("[%s] expected an ACR token generator: %t", tc.description, tc.expectedACR)
+			continue
+		}
+		credentials := map[string]*RegistryAuth{}
+		if generator != nil {
+			tokens, err := generator.TokenGenFxn()
+			if err == nil {
+				credentials = c.registryCredentials(&opssight, tokens, generator.Name)
+			}
+		}
+
+		users, passwords := registryUsersAndPasswords(opssight, credentials)
+		if fmt.Sprint(users) != fmt.Sprint(tc.expectedUsers) || fmt.Sprint(passwords) != fmt.Sprint(tc.expectedPasswords) {
+			t.Errorf("[%s] expected users %v and passwords %v, got %v and %v", tc.description, tc.expectedUsers, tc.expectedPasswords, users, passwords)
+		}
+	}
+}
+
+func TestACRExchangeAADToken(t *testing.T) {
+	var registry string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/oauth2/exchange" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.FormValue("grant_type") != "access_token" || r.FormValue("service") != registry || r.FormValue("access_token") != "aad-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"refresh_token": "refresh-%s"}`, r.FormValue("tenant"))
+	}))
+	defer server.Close()
+	registry = strings.TrimPrefix(server.URL, "https://")
+	acr := acrClient{client: server.Client()}
+
+	refreshToken, err := acr.ExchangeAADToken(registry, "tenant", "aad-token")
+	if err != nil || refreshToken != "refresh-tenant" {
+		t.Errorf("expected refresh token refresh-tenant, got %s: %v", refreshToken, err)
+	}
+	if _, err = acr.ExchangeAADToken(registry, "tenant", "expired"); err == nil {
+		t.Errorf("expected an error exchanging an invalid AAD token")
+	}
+}
+
+// fakeEcr returns tokens for the accounts it knows in its region
+type fakeEcr struct {
+	region   string
+	accounts map[string]bool
+	requests *[]string
+}
+
+func (e fakeEcr) GetAuthorizationToken(input *ecr.GetAuthorizationTokenInput) (*ecr.GetAuthorizationTokenOutput, error) {
+	accounts := aws.StringValueSlice(input.RegistryIds)
+	*e.requests = append(*e.requests, e.region+":"+strings.Join(accounts, ","))
+	output := &ecr.GetAuthorizationTokenOutput{}
+	for _, account := range accounts {
+		if !e.accounts[account] {
+			continue
+		}
+		output.AuthorizationData = append(output.AuthorizationData, &ecr.AuthorizationData{
+			AuthorizationToken: aws.String(base64.StdEncoding.EncodeToString([]byte("AWS:" + account + "-" + e.region))),
+			ProxyEndpoint:      aws.String(fmt.Sprintf("https://%s.dkr.ecr.%s.amazonaws.com", account, e.region)),
+			ExpiresAt:          aws.Time(time.Unix(1570000000, 0)),
+		})
+	}
+	return output, nil
+}
+
+// fakeEcrClients returns a client per region and role, where each role can only access some accounts
+type fakeEcrClients struct {
+	roleAccounts map[string]map[string]bool
+	requests     []string
+}
+
+func (e *fakeEcrClients) Client(registry ecrRegistry) ecrInterface {
+	return fakeEcr{region: registry.Region, accounts: e.roleAccounts[registry.RoleARN], requests: &e.requests}
+}
+
+func TestECRAuthorizationKey(t *testing.T) {
+	clients := &fakeEcrClients{roleAccounts: map[string]map[string]bool{
+		"":              {"111111111111": true},
+		"arn:prod-role": {"222222222222": true, "333333333333": true},
+	}}
+	ecrRegistryConfigs = []ecrRegistry{
+		{URL: "222222222222.dkr.ecr.eu-west-1.amazonaws.com", AccountID: "222222222222", Region: "eu-west-1", RoleARN: "arn:prod-role"},
+		{URL: "333333333333.dkr.ecr.eu-west-1.amazonaws.com", AccountID: "333333333333", Region: "eu-west-1", RoleARN: "arn:prod-role"},
+	}
+	defer func() { ecrRegistryConfigs = nil }()
+	c := &controller{ecrClients: clients}
+
+	opssight := newTestOpsSight(
+		"111111111111.dkr.ecr.us-east-1.amazonaws.com",
+		"222222222222.dkr.ecr.eu-west-1.amazonaws.com",
+		"333333333333.dkr.ecr.eu-west-1.amazonaws.com",
+		"444444444444.dkr.ecr.us-east-1.amazonaws.com",
+		"gcr.io")
+	var generator *TokenGenerator
+	for _, tokenGenerator := range c.getTokenGenerators([]opssightapi.OpsSight{opssight}) {
+		if tokenGenerator.Name == "ECR" {
+			generator = &tokenGenerator
+		}
+	}
+	if generator == nil {
+		t.Fatalf("expected an ECR token generator")
+	}
+
+	tokens, err := generator.TokenGenFxn()
+	if err == nil || !strings.Contains(err.Error(), "444444444444") {
+		t.Errorf("expected an error for the registry of account 444444444444, got %v", err)
+	}
+	credentials := c.registryCredentials(&opssight, tokens, generator.Name)
+
+	sort.Strings(clients.requests)
+	expectedRequests := []string{"eu-west-1:222222222222,333333333333", "us-east-1:111111111111,444444444444"}
+	if fmt.Sprint(clients.requests) != fmt.Sprint(expectedRequests) {
+		t.Errorf("expected requests %v, got %v", expectedRequests, clients.requests)
+	}
+
+	expectedUsers := []string{"AWS", "AWS", "AWS", "user", "user"}
+	expectedPasswords := []string{"111111111111-us-east-1", "222222222222-eu-west-1", "333333333333-eu-west-1", "password", "password"}
+	users, passwords := registryUsersAndPasswords(opssight, credentials)
+	if fmt.Sprint(users) != fmt.Sprint(expectedUsers) || fmt.Sprint(passwords) != fmt.Sprint(expectedPasswords) {
+		t.Errorf("expected users %v and passwords %v, got %v and %v", expectedUsers, expectedPasswords, users, passwords)
+	}
+	for _, token := range tokens {
+		if !token.ExpiresAt.Equal(time.Unix(1570000000, 0)) {
+			t.Errorf("expected the token of %s to expire at %s, got %s", token.Endpoint, time.Unix(1570000000, 0), token.ExpiresAt)
+		}
+	}
+}
+
+func TestParseECRRegistries(t *testing.T) {
+	testcases := []struct {
+		description string
+		config      string
+		expected    []ecrRegistry
+		expectedErr bool
+	}{
+		{
+			description: "account and region from the url",
+			config:      `[{"url": "https://123456789012.dkr.ecr.ap-south-1.amazonaws.com", "roleArn": "arn:aws:iam::123456789012:role/ecr-read"}]`,
+			expected:    []ecrRegistry{{URL: "123456789012.dkr.ecr.ap-south-1.amazonaws.com", AccountID: "123456789012", Region: "ap-south-1", RoleARN: "arn:aws:iam::123456789012:role/ecr-read"}},
+		},
+		{
+			description: "proxied registry",
+			config:      `[{"url": "ecr.example.com", "accountId": "123456789012", "region": "us-west-2", "roleArn": "arn:role", "webIdentityTokenFile": "/var/run/secrets/token"}]`,
+			expected:    []ecrRegistry{{URL: "ecr.example.com", AccountID: "123456789012", Region: "us-west-2", RoleARN: "arn:role", WebIdentityTokenFile: "/var/run/secrets/token"}},
+		},
+		{
+			description: "proxied registry without a region",
+			config:      `[{"url": "ecr.example.com", "accountId": "123456789012"}]`,
+			expectedErr: true,
+		},
+		{
+			description: "invalid json",
+			config:      `{"url": "ecr.example.com"}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		registries, err := parseECRRegistries(tc.config)
+		if (err != nil) != tc.expectedErr {
+			t.Errorf("[%s] expected an error: %t, got %v", tc.description, tc.expectedErr, err)
+		}
+		if fmt.Sprint(registries) != fmt.Sprint(tc.expected) && !tc.expectedErr {
+			t.Errorf("[%s] expected registries %+v, got %+v", tc.description, tc.expected, registries)
+		}
+	}
+}
+
+func TestMergeRegistryCredentials(t *testing.T) {
+	testcases := []struct {
+		description     string
+		data            string
+		credentials     map[string]*RegistryAuth
+		expectedData    string
+		expectedChanged bool
+		expectedErr     bool
+	}{
+		{
+			description:     "new secret",
+			credentials:     map[string]*RegistryAuth{"gcr.io": {URL: "gcr.io", User: "oauth2accesstoken", Password: "token"}},
+			expectedData:    `{"gcr.io":{"URL":"gcr.io","User":"oauth2accesstoken","Password":"token","Token":""}}`,
+			expectedChanged: true,
+		},
+		{
+			description:     "other registries are kept",
+			data:            `{"docker.io":{"url":"docker.io","user":"me","password":"secret"},"gcr.io":{"URL":"gcr.io","User":"oauth2accesstoken","Password":"old"}}`,
+			credentials:     map[string]*RegistryAuth{"gcr.io": {URL: "gcr.io", User: "oauth2accesstoken", Password: "token"}},
+			expectedData:    `{"docker.io":{"URL":"docker.io","User":"me","Password":"secret","Token":""},"gcr.io":{"URL":"gcr.io","User":"oauth2accesstoken","Password":"token","Token":""}}`,
+			expectedChanged: true,
+		},
+		{
+			description:  "unchanged token",
+			data:         `{"gcr.io":{"URL":"gcr.io","User":"oauth2accesstoken","Password":"token","Token":""}}`,
+			credentials:  map[string]*RegistryAuth{"gcr.io": {URL: "gcr.io", User: "oauth2accesstoken", Password: "token"}},
+			expectedData: `{"gcr.io":{"URL":"gcr.io","User":"oauth2accesstoken","Password":"token","Token":""}}`,
+		},
+		{
+			description: "invalid secret",
+			data:        `[]`,
+			credentials: map[string]*RegistryAuth{"gcr.io": {URL: "gcr.io", Password: "token"}},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		data, changed, err := mergeRegistryCredentials([]byte(tc.data), tc.credentials)
+		if (err != nil) != tc.expectedErr {
+			t.Errorf("[%s] expected an error: %t, got %v", tc.description, tc.expectedErr, err)
+			continue
+		}
+		if string(data) != tc.expectedData || changed != tc.expectedChanged {
+			t.Errorf("[%s] expected %s and changed %t, got %s and %t", tc.description, tc.expectedData, tc.expectedChanged, string(data), changed)
+		}
+	}
+}
+
+func TestReferenceCredentialsSecret(t *testing.T) {
+	opssight := newTestOpsSight("gcr.io", "docker.io")
+	opssight.Name = "opssight"
+	credentials := map[string]*RegistryAuth{"gcr.io": {URL: "gcr.io", User: "oauth2accesstoken", Password: "token"}}
+
+	if !referenceCredentialsSecret(&opssight, credentials) {
+		t.Errorf("expected the opssight to change")
+	}
+	imageFacade := opssight.Spec.ScannerPod.ImageFacade
+	if imageFacade.CredentialsSecret != "opssight-opssight-registry-credentials" {
+		t.Errorf("expected the opssight to reference secret opssight-opssight-registry-credentials, got %s", imageFacade.CredentialsSecret)
+	}
+	if imageFacade.InternalRegistries[0].Password != "" || imageFacade.InternalRegistries[1].Password != "password" {
+		t.Errorf("expected only the password of gcr.io to be removed, got %+v and %+v", *imageFacade.InternalRegistries[0], *imageFacade.InternalRegistries[1])
+	}
+
+	if referenceCredentialsSecret(&opssight, credentials) {
+		t.Errorf("expected the opssight to be unchanged once it references the secret")
+	}
+}
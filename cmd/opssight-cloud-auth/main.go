@@ -23,21 +23,28 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/blackducksoftware/synopsys-operator/pkg/protoform"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	flags             = flag.NewFlagSet("", flag.ContinueOnError)
-	argGCRURL         = flags.String(gcrURL, "https://gcr.io", `Default GCR URL`)
-	argAWSRegion      = flags.String(awsRegion, "us-east-1", `Default AWS region`)
-	argAWSAssumeRole  = flags.String(awsAssumeRole, "", `If specified AWS will assume this role and use it to retrieve tokens`)
-	argRefreshMinutes = flags.Int(refreshInMinutes, 60, `Default time to wait before refreshing (60 minutes)`)
+	flags              = flag.NewFlagSet("", flag.ContinueOnError)
+	argGCRURL          = flags.String(gcrURL, "https://gcr.io", `Default GCR URL`)
+	argAWSRegion       = flags.String(awsRegion, "us-east-1", `Default AWS region`)
+	argAWSAssumeRole   = flags.String(awsAssumeRole, "", `If specified AWS will assume this role and use it to retrieve tokens`)
+	argRefreshMinutes  = flags.Int(refreshInMinutes, 60, `Default time to wait before refreshing tokens with an unknown expiry, and before looking for new registries (60 minutes)`)
+	argRefreshFraction = flags.Float64(refreshFraction, 0.5, `Fraction of the token lifetime after which tokens are refreshed, can be overridden per provider with REFRESH_FRACTION_<GCR|ECR|ACR>`)
+	argStatusPort      = flags.Int(statusPort, 3001, `Port serving the refresh status at /status and the metrics at /metrics`)
+	argAzureTenantID   = flags.String(azureTenantID, "", `Azure tenant of the service principal used to retrieve ACR tokens`)
+	argAzureClientID   = flags.String(azureClientID, "", `Azure service principal or user assigned managed identity used to retrieve ACR tokens`)
 )
 
 func main() {
@@ -48,10 +55,14 @@ func main() {
 	validateParams()
 
 	log.Infof("Using GCR URL: %s", *argGCRURL)
-	log.Infof("Using AWS Account: %s", strings.Join(awsAccountIDs, ","))
-	log.Infof("Using AWS Region: %s", *argAWSRegion)
+	for _, registry := range ecrRegistryConfigs {
+		log.Infof("Using ECR Registry: %s (account %s, region %s, role %s)", registry.URL, registry.AccountID, registry.Region, registry.RoleARN)
+	}
 	log.Infof("Using AWS Assume Role: %s", *argAWSAssumeRole)
+	log.Infof("Using Azure Tenant: %s", *argAzureTenantID)
+	log.Infof("Using Azure Client: %s", *argAzureClientID)
 	log.Infof("Refresh Interval (minutes): %d", *argRefreshMinutes)
+	log.Infof("Refresh Fraction: %f", *argRefreshFraction)
 
 	kubeConfig, err := protoform.GetKubeConfig("", false)
 	if err != nil {
@@ -65,16 +76,35 @@ func main() {
 		os.Exit(1)
 	}
 
-	c := &controller{kubeConfig, kubeClient, newEcrClient(), newGcrClient()}
+	aadClient, err := newAadClient()
+	if err != nil {
+		log.Errorf("unable to create azure active directory client due to %+v", err)
+		os.Exit(1)
+	}
 
-	for {
-		err = c.updateAuthTokens()
-		if err != nil {
-			log.Error(err)
-			os.Exit(1)
-		}
-		time.Sleep(time.Duration(*argRefreshMinutes) * time.Minute)
+	c := &controller{
+		kubeConfig:             kubeConfig,
+		kubeClient:             kubeClient,
+		ecrClients:             newEcrClients(),
+		gcrClient:              newGcrClient(),
+		aadClient:              aadClient,
+		acrClient:              newAcrClient(),
+		refreshInterval:        time.Duration(*argRefreshMinutes) * time.Minute,
+		defaultRefreshFraction: *argRefreshFraction,
+		refreshFractions:       refreshFractions,
+		providerStatuses:       map[string]*providerStatus{},
 	}
+
+	http.Handle("/status", c)
+	http.Handle("/metrics", prometheus.Handler())
+	go func() {
+		log.Infof("serving the refresh status on :%d", *argStatusPort)
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", *argStatusPort), nil); err != nil {
+			log.Errorf("unable to serve the refresh status due to %+v", err)
+		}
+	}()
+
+	c.run(make(chan struct{}))
 }
 
 func validateParams() {
@@ -83,7 +113,11 @@ func validateParams() {
 	awsRegionEnv := os.Getenv(awsRegion)
 	argAWSAssumeRoleEnv := os.Getenv(awsAssumeRole)
 	argRefreshMinutesEnv := os.Getenv(refreshInMinutes)
+	argRefreshFractionEnv := os.Getenv(refreshFraction)
+	argStatusPortEnv := os.Getenv(statusPort)
 	gcrURLEnv := os.Getenv(gcrURL)
+	azureTenantIDEnv := os.Getenv(azureTenantID)
+	azureClientIDEnv := os.Getenv(azureClientID)
 
 	if len(awsRegionEnv) > 0 {
 		argAWSRegion = &awsRegionEnv
@@ -91,8 +125,6 @@ func validateParams() {
 
 	if len(awsAccountIDEnv) > 0 {
 		awsAccountIDs = strings.Split(awsAccountIDEnv, ",")
-	} else {
-		awsAccountIDs = []string{""}
 	}
 
 	if len(gcrURLEnv) > 0 {
@@ -103,8 +135,67 @@ func validateParams() {
 		argAWSAssumeRole = &argAWSAssumeRoleEnv
 	}
 
+	if len(azureTenantIDEnv) > 0 {
+		argAzureTenantID = &azureTenantIDEnv
+	}
+
+	if len(azureClientIDEnv) > 0 {
+		argAzureClientID = &azureClientIDEnv
+	}
+
 	if len(argRefreshMinutesEnv) > 0 {
 		refreshInterval, _ := strconv.Atoi(argRefreshMinutesEnv)
 		argRefreshMinutes = &refreshInterval
 	}
+
+	if len(argRefreshFractionEnv) > 0 {
+		fraction, err := strconv.ParseFloat(argRefreshFractionEnv, 64)
+		if err != nil || fraction <= 0 || fraction > 1 {
+			log.Errorf("%s must be a fraction between 0 and 1, not %s", refreshFraction, argRefreshFractionEnv)
+			os.Exit(1)
+		}
+		argRefreshFraction = &fraction
+	}
+
+	if len(argStatusPortEnv) > 0 {
+		port, _ := strconv.Atoi(argStatusPortEnv)
+		argStatusPort = &port
+	}
+
+	// The ECR registries of the AWS accounts are used if they aren't given
+	ecrRegistriesEnv := os.Getenv(ecrRegistries)
+	if len(ecrRegistriesEnv) > 0 {
+		registries, err := parseECRRegistries(ecrRegistriesEnv)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		ecrRegistryConfigs = registries
+	} else {
+		for _, accountID := range awsAccountIDs {
+			if len(accountID) > 0 {
+				ecrRegistryConfigs = append(ecrRegistryConfigs, ecrRegistry{
+					URL:       fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", accountID, *argAWSRegion),
+					AccountID: accountID,
+					Region:    *argAWSRegion,
+					RoleARN:   *argAWSAssumeRole,
+				})
+			}
+		}
+	}
+
+	refreshFractions = map[string]float64{}
+	for _, provider := range []string{"GCR", "ECR", "ACR"} {
+		name := fmt.Sprintf("%s_%s", refreshFraction, provider)
+		value := os.Getenv(name)
+		if len(value) == 0 {
+			continue
+		}
+		fraction, err := strconv.ParseFloat(value, 64)
+		if err != nil || fraction <= 0 || fraction > 1 {
+			log.Errorf("%s must be a fraction between 0 and 1, not %s", name, value)
+			os.Exit(1)
+		}
+		refreshFractions[provider] = fraction
+	}
 }
@@ -0,0 +1,83 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var refreshCounter *prometheus.CounterVec
+var lastRefreshGauge *prometheus.GaugeVec
+var tokenExpiryGauge *prometheus.GaugeVec
+var consecutiveFailuresGauge *prometheus.GaugeVec
+
+func recordRefresh(provider string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	refreshCounter.With(prometheus.Labels{"provider": provider, "result": result}).Inc()
+}
+
+func recordProviderStatus(status *providerStatus) {
+	if !status.LastRefresh.IsZero() {
+		lastRefreshGauge.With(prometheus.Labels{"provider": status.Name}).Set(float64(status.LastRefresh.Unix()))
+	}
+	if !status.ExpiresAt.IsZero() {
+		tokenExpiryGauge.With(prometheus.Labels{"provider": status.Name}).Set(float64(status.ExpiresAt.Unix()))
+	}
+	consecutiveFailuresGauge.With(prometheus.Labels{"provider": status.Name}).Set(float64(status.Failures))
+}
+
+func init() {
+	refreshCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "opssight",
+		Subsystem: "cloud_auth",
+		Name:      "token_refreshes",
+		Help:      "token refreshes by provider and result",
+	}, []string{"provider", "result"})
+	prometheus.MustRegister(refreshCounter)
+
+	lastRefreshGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "opssight",
+		Subsystem: "cloud_auth",
+		Name:      "last_refresh_timestamp_seconds",
+		Help:      "time of the last successful token refresh by provider",
+	}, []string{"provider"})
+	prometheus.MustRegister(lastRefreshGauge)
+
+	tokenExpiryGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "opssight",
+		Subsystem: "cloud_auth",
+		Name:      "token_expiry_timestamp_seconds",
+		Help:      "time the earliest token of a provider expires",
+	}, []string{"provider"})
+	prometheus.MustRegister(tokenExpiryGauge)
+
+	consecutiveFailuresGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "opssight",
+		Subsystem: "cloud_auth",
+		Name:      "consecutive_refresh_failures",
+		Help:      "failed token refreshes of a provider since its last successful refresh",
+	}, []string{"provider"})
+	prometheus.MustRegister(consecutiveFailuresGauge)
+}
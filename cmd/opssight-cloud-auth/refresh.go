@@ -0,0 +1,180 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	minRefreshDelay = time.Minute
+	minRetryDelay   = 30 * time.Second
+)
+
+// providerStatus is the refresh status of the tokens of a provider
+type providerStatus struct {
+	Name        string
+	LastAttempt time.Time
+	LastRefresh time.Time
+	LastError   string
+	ExpiresAt   time.Time
+	NextRefresh time.Time
+	Failures    int
+}
+
+// refreshFraction returns the fraction of the token lifetime after which the tokens
+// of the provider are refreshed
+func (c *controller) refreshFraction(provider string) float64 {
+	if fraction, ok := c.refreshFractions[provider]; ok {
+		return fraction
+	}
+	return c.defaultRefreshFraction
+}
+
+// refreshDelay returns how long to wait before refreshing tokens.  Tokens are refreshed at the
+// refresh fraction of the lifetime of the first one to expire, or after the refresh interval
+// if they don't expire
+func (c *controller) refreshDelay(provider string, tokens []AuthToken, now time.Time) time.Duration {
+	expiresAt := earliestExpiry(tokens)
+	if expiresAt.IsZero() {
+		return c.refreshInterval
+	}
+	delay := time.Duration(float64(expiresAt.Sub(now)) * c.refreshFraction(provider))
+	if delay < minRefreshDelay {
+		return minRefreshDelay
+	}
+	return delay
+}
+
+// retryDelay returns how long to wait before retrying after consecutive failures, doubling
+// from 30 seconds up to the refresh interval
+func (c *controller) retryDelay(failures int) time.Duration {
+	delay := time.Duration(float64(minRetryDelay) * math.Pow(2, float64(failures-1)))
+	if delay > c.refreshInterval || delay <= 0 {
+		return c.refreshInterval
+	}
+	return delay
+}
+
+func earliestExpiry(tokens []AuthToken) time.Time {
+	expiresAt := time.Time{}
+	for _, token := range tokens {
+		if !token.ExpiresAt.IsZero() && (expiresAt.IsZero() || token.ExpiresAt.Before(expiresAt)) {
+			expiresAt = token.ExpiresAt
+		}
+	}
+	return expiresAt
+}
+
+// getProviderStatus returns the status of a provider, creating it if needed
+func (c *controller) getProviderStatus(provider string) *providerStatus {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+	status, ok := c.providerStatuses[provider]
+	if !ok {
+		status = &providerStatus{Name: provider}
+		c.providerStatuses[provider] = status
+	}
+	return status
+}
+
+// refreshProvider generates new tokens if the provider is due for a refresh and passes them
+// to update.  It returns when the provider is next due
+func (c *controller) refreshProvider(tokenGenerator TokenGenerator, now time.Time, update func([]AuthToken) error) time.Time {
+	status := c.getProviderStatus(tokenGenerator.Name)
+	c.statusMutex.Lock()
+	nextRefresh := status.NextRefresh
+	c.statusMutex.Unlock()
+	if now.Before(nextRefresh) {
+		return nextRefresh
+	}
+
+	tokens, err := tokenGenerator.TokenGenFxn()
+	// Apply the tokens that were generated even if others failed, the refresh is retried
+	if err == nil || len(tokens) > 0 {
+		log.Debugf("new tokens for %s provider is %+v", tokenGenerator.Name, tokens)
+		if updateErr := update(tokens); updateErr != nil {
+			if err == nil {
+				err = updateErr
+			} else {
+				err = fmt.Errorf("%v; %v", err, updateErr)
+			}
+		}
+	}
+	recordRefresh(tokenGenerator.Name, err)
+
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+	status.LastAttempt = now
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+		status.NextRefresh = now.Add(c.retryDelay(status.Failures))
+		log.Errorf("unable to refresh tokens for provider %s, retrying at %s: %+v", tokenGenerator.Name, status.NextRefresh, err)
+	} else {
+		status.Failures = 0
+		status.LastError = ""
+		status.LastRefresh = now
+		status.ExpiresAt = earliestExpiry(tokens)
+		status.NextRefresh = now.Add(c.refreshDelay(tokenGenerator.Name, tokens, now))
+		log.Infof("refreshed tokens for provider %s, next refresh at %s", tokenGenerator.Name, status.NextRefresh)
+	}
+	recordProviderStatus(status)
+	return status.NextRefresh
+}
+
+// isHealthy returns whether no provider has let previously refreshed tokens expire
+func (c *controller) isHealthy(now time.Time) bool {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+	for _, status := range c.providerStatuses {
+		if !status.ExpiresAt.IsZero() && status.Failures > 0 && now.After(status.ExpiresAt) {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP serves the refresh status of the providers as json, with status code 503
+// if the tokens of a provider have expired
+func (c *controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.statusMutex.Lock()
+	statuses := []providerStatus{}
+	for _, status := range c.providerStatuses {
+		statuses = append(statuses, *status)
+	}
+	c.statusMutex.Unlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	if !c.isHealthy(time.Now()) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(statuses)
+}
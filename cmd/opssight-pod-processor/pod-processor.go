@@ -49,6 +49,9 @@ func main() {
 				MapCompareFunc: oca.MapContainsBlackDuckEntries,
 			},
 		},
+		PodEventHandlerFuncs: annotations.PodEventHandlerFuncs{
+			PodEventCreationFunc: oca.CreatePodEvents,
+		},
 	}
 
 	// Create the Pod Perceiver
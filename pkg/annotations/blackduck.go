@@ -24,6 +24,7 @@ package annotations
 import (
 	"encoding/json"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -107,7 +108,7 @@ func CreateBlackDuckVulnerabilityAnnotation(hasVulns bool, url string, vulnCount
 		[]summaryEntry{
 			{
 				Label:         "high",
-				Data:          string(vulnCount),
+				Data:          strconv.Itoa(vulnCount),
 				SeverityIndex: 1,
 			},
 		},
@@ -127,7 +128,7 @@ func CreateBlackDuckPolicyAnnotation(hasPolicyViolations bool, url string, polic
 		[]summaryEntry{
 			{
 				Label:         "important",
-				Data:          string(policyCount),
+				Data:          strconv.Itoa(policyCount),
 				SeverityIndex: 1,
 			},
 		},
@@ -23,6 +23,7 @@ package annotations
 
 import (
 	"encoding/json"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -38,7 +39,7 @@ func createObj(name string, desc string, ts string, ref string, ver string, com
 		Summary: []summaryEntry{
 			{
 				Label:         label,
-				Data:          string(score),
+				Data:          strconv.Itoa(score),
 				SeverityIndex: index,
 			},
 		},
@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package annotations
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/blackducksoftware/perceivers/pkg/annotations"
+
+	"k8s.io/api/core/v1"
+)
+
+// CreatePodEvents returns the events that should be recorded on a pod whose
+// annotations changed from oldAnnotations to newAnnotations.  Events are only
+// returned for transitions, so calling this with unchanged annotations returns none
+func CreatePodEvents(oldAnnotations map[string]string, newAnnotations map[string]string) []annotations.PodEvent {
+	events := []annotations.PodEvent{}
+	vulnKey := fmt.Sprintf("%s/vulnerability.blackduck", PodAnnotationPrefix)
+	policyKey := fmt.Sprintf("%s/policy.blackduck", PodAnnotationPrefix)
+
+	newVulns, err := newBlackDuckAnnotationFromMap(newAnnotations, vulnKey)
+	if err != nil {
+		return events
+	}
+	newPolicy, err := newBlackDuckAnnotationFromMap(newAnnotations, policyKey)
+	if err != nil {
+		return events
+	}
+
+	oldVulnsJSON, ok := oldAnnotations[vulnKey]
+	if !ok {
+		events = append(events, *annotations.NewPodEvent(v1.EventTypeNormal, annotations.EventReasonImageScanComplete,
+			fmt.Sprintf("Black Duck scan complete: %d vulnerabilities, %d policy violations", newVulns.summaryCount(), newPolicy.summaryCount())))
+	} else if !CompareBlackDuckAnnotationJSON(oldVulnsJSON, newAnnotations[vulnKey]) {
+		oldVulns, err := NewBlackDuckAnnotationFromJSON(oldVulnsJSON)
+		if err == nil && oldVulns.hasSummaryCount() && newVulns.hasSummaryCount() && newVulns.summaryCount() > oldVulns.summaryCount() {
+			events = append(events, *annotations.NewPodEvent(v1.EventTypeWarning, annotations.EventReasonVulnerabilitiesIncreased,
+				fmt.Sprintf("vulnerabilities increased from %d to %d", oldVulns.summaryCount(), newVulns.summaryCount())))
+		}
+	}
+
+	if !newPolicy.Compliant {
+		oldPolicy, err := newBlackDuckAnnotationFromMap(oldAnnotations, policyKey)
+		if err != nil || oldPolicy.Compliant {
+			events = append(events, *annotations.NewPodEvent(v1.EventTypeWarning, annotations.EventReasonPolicyViolationDetected,
+				fmt.Sprintf("%d Black Duck policy violations detected", newPolicy.summaryCount())))
+		}
+	}
+
+	return events
+}
+
+func newBlackDuckAnnotationFromMap(m map[string]string, key string) (*BlackDuckAnnotation, error) {
+	data, ok := m[key]
+	if !ok {
+		return nil, fmt.Errorf("annotation %s not found", key)
+	}
+	return NewBlackDuckAnnotationFromJSON(data)
+}
+
+// hasSummaryCount returns true if the first summary entry holds a count
+func (bda *BlackDuckAnnotation) hasSummaryCount() bool {
+	if len(bda.Summary) == 0 {
+		return false
+	}
+	_, err := strconv.Atoi(bda.Summary[0].Data)
+	return err == nil
+}
+
+// summaryCount returns the count held in the first summary entry, or 0 if
+// there isn't one
+func (bda *BlackDuckAnnotation) summaryCount() int {
+	if len(bda.Summary) == 0 {
+		return 0
+	}
+	count, _ := strconv.Atoi(bda.Summary[0].Data)
+	return count
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (C) 2018 Synopsys, Inc.
+
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements. See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership. The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied. See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package annotations
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blackducksoftware/perceivers/pkg/annotations"
+)
+
+func createPodAnnotationMap(vulns int, policies int) map[string]string {
+	return map[string]string{
+		fmt.Sprintf("%s/vulnerability.blackduck", PodAnnotationPrefix): CreateBlackDuckVulnerabilityAnnotation(vulns > 0, "", vulns, "1.2.3").AsString(),
+		fmt.Sprintf("%s/policy.blackduck", PodAnnotationPrefix):        CreateBlackDuckPolicyAnnotation(policies > 0, "", policies, "1.2.3").AsString(),
+	}
+}
+
+func TestCreatePodEvents(t *testing.T) {
+	testcases := []struct {
+		description    string
+		oldAnnotations map[string]string
+		newAnnotations map[string]string
+		reasons        []string
+	}{
+		{
+			description:    "first scan without issues",
+			oldAnnotations: map[string]string{},
+			newAnnotations: createPodAnnotationMap(0, 0),
+			reasons:        []string{annotations.EventReasonImageScanComplete},
+		},
+		{
+			description:    "first scan with policy violations",
+			oldAnnotations: map[string]string{},
+			newAnnotations: createPodAnnotationMap(2, 1),
+			reasons:        []string{annotations.EventReasonImageScanComplete, annotations.EventReasonPolicyViolationDetected},
+		},
+		{
+			description:    "unchanged results",
+			oldAnnotations: createPodAnnotationMap(2, 1),
+			newAnnotations: createPodAnnotationMap(2, 1),
+			reasons:        []string{},
+		},
+		{
+			description:    "vulnerabilities increased",
+			oldAnnotations: createPodAnnotationMap(2, 0),
+			newAnnotations: createPodAnnotationMap(5, 0),
+			reasons:        []string{annotations.EventReasonVulnerabilitiesIncreased},
+		},
+		{
+			description:    "vulnerabilities decreased",
+			oldAnnotations: createPodAnnotationMap(5, 0),
+			newAnnotations: createPodAnnotationMap(2, 0),
+			reasons:        []string{},
+		},
+		{
+			description:    "newly violates policy",
+			oldAnnotations: createPodAnnotationMap(0, 0),
+			newAnnotations: createPodAnnotationMap(0, 3),
+			reasons:        []string{annotations.EventReasonPolicyViolationDetected},
+		},
+		{
+			description:    "still violates policy",
+			oldAnnotations: createPodAnnotationMap(0, 1),
+			newAnnotations: createPodAnnotationMap(0, 3),
+			reasons:        []string{},
+		},
+		{
+			description:    "no new annotations",
+			oldAnnotations: createPodAnnotationMap(0, 1),
+			newAnnotations: map[string]string{},
+			reasons:        []string{},
+		},
+	}
+
+	for _, tc := range testcases {
+		events := CreatePodEvents(tc.oldAnnotations, tc.newAnnotations)
+		if len(events) != len(tc.reasons) {
+			t.Errorf("[%s] expected %d events got %d: %v", tc.description, len(tc.reasons), len(events), events)
+			continue
+		}
+		for pos, event := range events {
+			if event.Reason != tc.reasons[pos] {
+				t.Errorf("[%s] expected event %d to have reason %s got %s", tc.description, pos, tc.reasons[pos], event.Reason)
+			}
+		}
+	}
+}